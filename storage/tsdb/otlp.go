@@ -0,0 +1,290 @@
+package tsdb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+
+	"github.com/nuclio/logger"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/v3io/v3io-tsdb/pkg/config"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/pmetricotlp"
+)
+
+// tenantAppenderProvider resolves a storage.Appender for a given tenant. V3ioPromAdapter satisfies this via
+// its TenantAppender method; it lets the OTLP ingestion handler route each request to its own tenant's
+// appender instead of being bound to a single one at construction time.
+type tenantAppenderProvider interface {
+	TenantAppender(tenant string) (storage.Appender, error)
+}
+
+// otlpIngester converts incoming OTLP metrics into v3io TSDB samples, resolving a tenant-scoped
+// storage.Appender per request from appenders.
+type otlpIngester struct {
+	appenders              tenantAppenderProvider
+	logger                 logger.Logger
+	convertStartTimestamps bool
+}
+
+// NewOTLPHandler returns an http.Handler that accepts OTLP/HTTP metrics export requests and appends the
+// contained data points via a storage.Appender resolved per request from appenders, using the tenant
+// attached to the request context (see TenantMiddleware). When cfg.OtlpConvertStartTimestamps is set, a
+// zero-valued sample is synthesized at the start timestamp of each cumulative data point of a monotonic sum,
+// a histogram or a summary, so that rate() and increase() reset correctly across scrape resets and process
+// restarts. Gauges and non-monotonic sums never get one.
+func NewOTLPHandler(appenders tenantAppenderProvider, cfg *config.V3ioConfig, logger logger.Logger) http.Handler {
+	ing := &otlpIngester{
+		appenders:              appenders,
+		logger:                 logger.GetChild("otlp-ingester"),
+		convertStartTimestamps: cfg.OtlpConvertStartTimestamps,
+	}
+
+	return http.HandlerFunc(ing.ServeHTTP)
+}
+
+func (ing *otlpIngester) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	appender, err := ing.appenders.TenantAppender(TenantFromContext(r.Context()))
+	if err != nil {
+		http.Error(w, errors.Wrap(err, "failed to resolve tenant appender").Error(), http.StatusBadRequest)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, errors.Wrap(err, "failed to read request body").Error(), http.StatusBadRequest)
+		return
+	}
+
+	req := pmetricotlp.NewExportRequest()
+	if err := req.UnmarshalProto(body); err != nil {
+		http.Error(w, errors.Wrap(err, "failed to unmarshal OTLP metrics").Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := ing.convert(req.Metrics(), appender); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := appender.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (ing *otlpIngester) convert(metrics pmetric.Metrics, appender storage.Appender) error {
+	resourceMetrics := metrics.ResourceMetrics()
+	for i := 0; i < resourceMetrics.Len(); i++ {
+		rm := resourceMetrics.At(i)
+		resourceLabels := attributesToLabels(rm.Resource().Attributes())
+
+		scopeMetrics := rm.ScopeMetrics()
+		for j := 0; j < scopeMetrics.Len(); j++ {
+			sm := scopeMetrics.At(j)
+			scopeLabels := attributesToLabels(sm.Scope().Attributes())
+
+			ms := sm.Metrics()
+			for k := 0; k < ms.Len(); k++ {
+				if err := ing.convertMetric(ms.At(k), resourceLabels, scopeLabels, appender); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (ing *otlpIngester) convertMetric(metric pmetric.Metric, resourceLabels, scopeLabels labels.Labels,
+	appender storage.Appender) error {
+
+	switch metric.Type() {
+	case pmetric.MetricTypeSum:
+		sum := metric.Sum()
+		// Upstream Prometheus's OTLP translator only synthesizes a created-timestamp marker for monotonic
+		// cumulative sums; a non-monotonic sum can legitimately decrease, so a synthesized zero would be a
+		// fabricated data point rather than a reset marker.
+		isCumulative := sum.AggregationTemporality() == pmetric.AggregationTemporalityCumulative && sum.IsMonotonic()
+		return ing.addNumberDataPoints(metric.Name(), sum.DataPoints(), resourceLabels, scopeLabels, isCumulative, appender)
+	case pmetric.MetricTypeGauge:
+		// Gauges are not monotonic, so they never carry a created-timestamp marker.
+		return ing.addNumberDataPoints(metric.Name(), metric.Gauge().DataPoints(), resourceLabels, scopeLabels, false, appender)
+	case pmetric.MetricTypeHistogram:
+		hist := metric.Histogram()
+		isCumulative := hist.AggregationTemporality() == pmetric.AggregationTemporalityCumulative
+		return ing.addHistogramDataPoints(metric.Name(), hist.DataPoints(), resourceLabels, scopeLabels, isCumulative, appender)
+	case pmetric.MetricTypeSummary:
+		return ing.addSummaryDataPoints(metric.Name(), metric.Summary().DataPoints(), resourceLabels, scopeLabels, appender)
+	default:
+		ing.logger.Warn("Ignoring OTLP metric '%s' with unsupported type %v", metric.Name(), metric.Type())
+		return nil
+	}
+}
+
+func (ing *otlpIngester) addNumberDataPoints(name string, dps pmetric.NumberDataPointSlice, resourceLabels,
+	scopeLabels labels.Labels, isCumulative bool, appender storage.Appender) error {
+
+	for i := 0; i < dps.Len(); i++ {
+		dp := dps.At(i)
+		lset := dataPointLabels(name, resourceLabels, scopeLabels, dp.Attributes())
+
+		if isCumulative && ing.convertStartTimestamps {
+			if err := ing.addCreatedTimestamp(lset, dp.StartTimestamp(), dp.Timestamp(), appender); err != nil {
+				return err
+			}
+		}
+
+		if _, err := appender.Add(lset, dp.Timestamp().AsTime().UnixNano()/int64(1e6), dp.DoubleValue()); err != nil {
+			return errors.Wrapf(err, "failed to append sample for metric '%s'", name)
+		}
+	}
+
+	return nil
+}
+
+func (ing *otlpIngester) addHistogramDataPoints(name string, dps pmetric.HistogramDataPointSlice, resourceLabels,
+	scopeLabels labels.Labels, isCumulative bool, appender storage.Appender) error {
+
+	for i := 0; i < dps.Len(); i++ {
+		dp := dps.At(i)
+
+		sumLabels := dataPointLabels(name+"_sum", resourceLabels, scopeLabels, dp.Attributes())
+		countLabels := dataPointLabels(name+"_count", resourceLabels, scopeLabels, dp.Attributes())
+
+		if isCumulative && ing.convertStartTimestamps {
+			if err := ing.addCreatedTimestamp(sumLabels, dp.StartTimestamp(), dp.Timestamp(), appender); err != nil {
+				return err
+			}
+			if err := ing.addCreatedTimestamp(countLabels, dp.StartTimestamp(), dp.Timestamp(), appender); err != nil {
+				return err
+			}
+		}
+
+		t := dp.Timestamp().AsTime().UnixNano() / int64(1e6)
+		if _, err := appender.Add(sumLabels, t, dp.Sum()); err != nil {
+			return errors.Wrapf(err, "failed to append sum sample for metric '%s'", name)
+		}
+		if _, err := appender.Add(countLabels, t, float64(dp.Count())); err != nil {
+			return errors.Wrapf(err, "failed to append count sample for metric '%s'", name)
+		}
+
+		// BucketCounts has one more entry than ExplicitBounds: the last one is the overflow/+Inf bucket,
+		// whose cumulative count must equal dp.Count() for histogram_quantile() to work.
+		bounds := dp.ExplicitBounds()
+		bucketCounts := dp.BucketCounts()
+		var cumulative uint64
+		for b := 0; b < bucketCounts.Len(); b++ {
+			cumulative += bucketCounts.At(b)
+
+			le := "+Inf"
+			if b < bounds.Len() {
+				le = fmt.Sprintf("%g", bounds.At(b))
+			}
+			bucketLabels := append(dataPointLabels(name+"_bucket", resourceLabels, scopeLabels, dp.Attributes()),
+				labels.Label{Name: "le", Value: le})
+
+			if isCumulative && ing.convertStartTimestamps {
+				if err := ing.addCreatedTimestamp(bucketLabels, dp.StartTimestamp(), dp.Timestamp(), appender); err != nil {
+					return err
+				}
+			}
+
+			if _, err := appender.Add(bucketLabels, t, float64(cumulative)); err != nil {
+				return errors.Wrapf(err, "failed to append bucket sample for metric '%s'", name)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (ing *otlpIngester) addSummaryDataPoints(name string, dps pmetric.SummaryDataPointSlice, resourceLabels,
+	scopeLabels labels.Labels, appender storage.Appender) error {
+
+	for i := 0; i < dps.Len(); i++ {
+		dp := dps.At(i)
+		t := dp.Timestamp().AsTime().UnixNano() / int64(1e6)
+
+		sumLabels := dataPointLabels(name+"_sum", resourceLabels, scopeLabels, dp.Attributes())
+		countLabels := dataPointLabels(name+"_count", resourceLabels, scopeLabels, dp.Attributes())
+
+		if ing.convertStartTimestamps {
+			if err := ing.addCreatedTimestamp(sumLabels, dp.StartTimestamp(), dp.Timestamp(), appender); err != nil {
+				return err
+			}
+			if err := ing.addCreatedTimestamp(countLabels, dp.StartTimestamp(), dp.Timestamp(), appender); err != nil {
+				return err
+			}
+		}
+
+		if _, err := appender.Add(sumLabels, t, dp.Sum()); err != nil {
+			return errors.Wrapf(err, "failed to append sum sample for metric '%s'", name)
+		}
+		if _, err := appender.Add(countLabels, t, float64(dp.Count())); err != nil {
+			return errors.Wrapf(err, "failed to append count sample for metric '%s'", name)
+		}
+
+		quantiles := dp.QuantileValues()
+		for q := 0; q < quantiles.Len(); q++ {
+			qv := quantiles.At(q)
+			qLabels := append(dataPointLabels(name, resourceLabels, scopeLabels, dp.Attributes()),
+				labels.Label{Name: "quantile", Value: fmt.Sprintf("%g", qv.Quantile())})
+
+			if _, err := appender.Add(qLabels, t, qv.Value()); err != nil {
+				return errors.Wrapf(err, "failed to append quantile sample for metric '%s'", name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// addCreatedTimestamp synthesizes a zero-valued sample at startTime, unless a sample already exists there
+// (start and current timestamps being equal means there is nothing to reset from).
+func (ing *otlpIngester) addCreatedTimestamp(lset labels.Labels, start, current pcommon.Timestamp, appender storage.Appender) error {
+	if start == 0 || start == current {
+		return nil
+	}
+
+	// StartTimestamp is constant across a cumulative series' lifetime, so this re-inserts the same sample on
+	// every scrape after the first. That's expected to come back as storage.ErrDuplicateSampleForTimestamp,
+	// Prometheus's sentinel for "a sample already exists at this timestamp" — match on that instead of
+	// sniffing the underlying appender's error text, which isn't pinned anywhere in this series.
+	startMillis := start.AsTime().UnixNano() / int64(1e6)
+	_, err := appender.Add(lset, startMillis, 0)
+	if err != nil && errors.Cause(err) != storage.ErrDuplicateSampleForTimestamp {
+		return errors.Wrap(err, "failed to append created-timestamp sample")
+	}
+
+	return nil
+}
+
+// dataPointLabels assembles the label set identifying a data point's series. The result is sorted since
+// series identity (labels.Labels.Hash/GetKey) assumes a canonical order, and pmetric.Map.Range iterates
+// attributes in an unspecified order.
+func dataPointLabels(name string, resourceLabels, scopeLabels labels.Labels, attrs pcommon.Map) labels.Labels {
+	lset := labels.Labels{{Name: labels.MetricName, Value: name}}
+	lset = append(lset, resourceLabels...)
+	lset = append(lset, scopeLabels...)
+	lset = append(lset, attributesToLabels(attrs)...)
+
+	sort.Sort(lset)
+	return lset
+}
+
+func attributesToLabels(attrs pcommon.Map) labels.Labels {
+	var lset labels.Labels
+	attrs.Range(func(k string, v pcommon.Value) bool {
+		lset = append(lset, labels.Label{Name: k, Value: v.AsString()})
+		return true
+	})
+
+	return lset
+}
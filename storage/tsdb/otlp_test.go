@@ -0,0 +1,188 @@
+package tsdb
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+var (
+	time0   = pcommon.NewTimestampFromTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	time30s = pcommon.NewTimestampFromTime(time.Date(2024, 1, 1, 0, 0, 30, 0, time.UTC))
+)
+
+// fakeAppender records every Add call, mirroring v3ioAppender closely enough to assert on sample order and
+// timestamps without a real v3io-tsdb backend.
+type fakeAppender struct {
+	samples []fakeSample
+}
+
+type fakeSample struct {
+	lset labels.Labels
+	t    int64
+	v    float64
+}
+
+func (a *fakeAppender) Add(lset labels.Labels, t int64, v float64) (uint64, error) {
+	a.samples = append(a.samples, fakeSample{lset: lset, t: t, v: v})
+	return uint64(len(a.samples)), nil
+}
+
+func (a *fakeAppender) AddFast(lset labels.Labels, ref uint64, t int64, v float64) error {
+	_, err := a.Add(lset, t, v)
+	return err
+}
+
+func (a *fakeAppender) Commit() error   { return nil }
+func (a *fakeAppender) Rollback() error { return nil }
+
+func newTestIngester(t *testing.T, convertStartTimestamps bool) *otlpIngester {
+	return &otlpIngester{logger: testLogger(t), convertStartTimestamps: convertStartTimestamps}
+}
+
+func TestDataPointLabelsSorted(t *testing.T) {
+	resourceLabels := labels.Labels{{Name: "zres", Value: "1"}, {Name: "ares", Value: "1"}}
+	scopeLabels := labels.Labels{{Name: "zscope", Value: "1"}}
+	attrs := pcommon.NewMap()
+	attrs.PutStr("zattr", "1")
+	attrs.PutStr("battr", "1")
+
+	lset := dataPointLabels("metric", resourceLabels, scopeLabels, attrs)
+
+	if !sort.IsSorted(lset) {
+		t.Fatalf("dataPointLabels() = %v, want sorted", lset)
+	}
+}
+
+func TestConvertMetricCumulativeMonotonicSumAddsCreatedTimestamp(t *testing.T) {
+	ing := newTestIngester(t, true)
+	appender := &fakeAppender{}
+
+	metric := pmetric.NewMetric()
+	metric.SetName("requests_total")
+	sum := metric.SetEmptySum()
+	sum.SetAggregationTemporality(pmetric.AggregationTemporalityCumulative)
+	sum.SetIsMonotonic(true)
+	dp := sum.DataPoints().AppendEmpty()
+	dp.SetStartTimestamp(time0)
+	dp.SetTimestamp(time30s)
+	dp.SetDoubleValue(5)
+
+	if err := ing.convertMetric(metric, nil, nil, appender); err != nil {
+		t.Fatalf("convertMetric() error = %v", err)
+	}
+
+	if len(appender.samples) != 2 {
+		t.Fatalf("got %d samples, want 2 (created-timestamp marker + real sample)", len(appender.samples))
+	}
+	if appender.samples[0].v != 0 {
+		t.Errorf("created-timestamp sample value = %v, want 0", appender.samples[0].v)
+	}
+	if appender.samples[1].v != 5 {
+		t.Errorf("real sample value = %v, want 5", appender.samples[1].v)
+	}
+}
+
+func TestConvertMetricNonMonotonicSumSkipsCreatedTimestamp(t *testing.T) {
+	ing := newTestIngester(t, true)
+	appender := &fakeAppender{}
+
+	metric := pmetric.NewMetric()
+	metric.SetName("queue_size")
+	sum := metric.SetEmptySum()
+	sum.SetAggregationTemporality(pmetric.AggregationTemporalityCumulative)
+	sum.SetIsMonotonic(false)
+	dp := sum.DataPoints().AppendEmpty()
+	dp.SetStartTimestamp(time0)
+	dp.SetTimestamp(time30s)
+	dp.SetDoubleValue(5)
+
+	if err := ing.convertMetric(metric, nil, nil, appender); err != nil {
+		t.Fatalf("convertMetric() error = %v", err)
+	}
+
+	if len(appender.samples) != 1 {
+		t.Fatalf("got %d samples, want 1 (no created-timestamp marker for a non-monotonic sum)", len(appender.samples))
+	}
+	if appender.samples[0].v != 5 {
+		t.Errorf("sample value = %v, want 5", appender.samples[0].v)
+	}
+}
+
+func TestConvertMetricGaugeNoCreatedTimestamp(t *testing.T) {
+	ing := newTestIngester(t, true)
+	appender := &fakeAppender{}
+
+	metric := pmetric.NewMetric()
+	metric.SetName("cpu_temp")
+	gauge := metric.SetEmptyGauge()
+	dp := gauge.DataPoints().AppendEmpty()
+	dp.SetStartTimestamp(time0)
+	dp.SetTimestamp(time30s)
+	dp.SetDoubleValue(42)
+
+	if err := ing.convertMetric(metric, nil, nil, appender); err != nil {
+		t.Fatalf("convertMetric() error = %v", err)
+	}
+
+	if len(appender.samples) != 1 {
+		t.Fatalf("got %d samples, want 1 (gauges never carry a created-timestamp marker)", len(appender.samples))
+	}
+	if appender.samples[0].v != 42 {
+		t.Errorf("sample value = %v, want 42", appender.samples[0].v)
+	}
+}
+
+func TestConvertMetricHistogramBucketSeries(t *testing.T) {
+	ing := newTestIngester(t, false)
+	appender := &fakeAppender{}
+
+	metric := pmetric.NewMetric()
+	metric.SetName("latency")
+	hist := metric.SetEmptyHistogram()
+	dp := hist.DataPoints().AppendEmpty()
+	dp.SetTimestamp(time30s)
+	dp.SetSum(12.5)
+	dp.SetCount(3)
+	dp.ExplicitBounds().FromRaw([]float64{0.5, 1})
+	dp.BucketCounts().FromRaw([]uint64{1, 1, 1})
+
+	if err := ing.convertMetric(metric, nil, nil, appender); err != nil {
+		t.Fatalf("convertMetric() error = %v", err)
+	}
+
+	// sum + count + one series per explicit bound + the +Inf overflow bucket.
+	if len(appender.samples) != 5 {
+		t.Fatalf("got %d samples, want 5 (sum, count, 2 explicit buckets, +Inf bucket)", len(appender.samples))
+	}
+
+	wantSuffixes := map[string]bool{"latency_sum": false, "latency_count": false, "latency_bucket": false}
+	var infBucket *fakeSample
+	for i, s := range appender.samples {
+		name := s.lset.Get(labels.MetricName)
+		if _, ok := wantSuffixes[name]; ok {
+			wantSuffixes[name] = true
+		}
+		if name == "latency_bucket" && s.lset.Get("le") == "+Inf" {
+			infBucket = &appender.samples[i]
+		}
+	}
+	for name, seen := range wantSuffixes {
+		if !seen {
+			t.Errorf("missing expected series %q among appended samples", name)
+		}
+	}
+
+	// The +Inf bucket's cumulative count must equal dp.Count(), or histogram_quantile() over this series
+	// will never reach 1.0.
+	if infBucket == nil {
+		t.Fatal("missing +Inf overflow bucket among appended samples")
+	}
+	if infBucket.v != float64(dp.Count()) {
+		t.Errorf("+Inf bucket value = %v, want %v (dp.Count())", infBucket.v, dp.Count())
+	}
+}
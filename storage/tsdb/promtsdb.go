@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/nuclio/logger"
 	"github.com/pkg/errors"
@@ -18,10 +19,15 @@ import (
 )
 
 type V3ioPromAdapter struct {
-	db     *tsdb.V3ioAdapter
-	logger logger.Logger
+	baseCfg *config.V3ioConfig
+	logger  logger.Logger
 
 	useV3ioAggregations bool // Indicate whether or not to use v3io aggregations by default (passed from prometheus.yml)
+	defaultQueryLimit   int  // Default max number of series/label values a query returns (passed from prometheus.yml)
+
+	tenantsLock    sync.RWMutex
+	tenants        map[string]*tsdb.V3ioAdapter
+	allowedTenants map[string]bool // nil means no allow-list restriction
 }
 
 func NewV3ioProm(cfg *config.V3ioConfig, logger logger.Logger) (*V3ioPromAdapter, error) {
@@ -34,50 +40,145 @@ func NewV3ioProm(cfg *config.V3ioConfig, logger logger.Logger) (*V3ioPromAdapter
 		logger = newLogger
 	}
 
+	newAdapter := V3ioPromAdapter{
+		baseCfg:        cfg,
+		logger:         logger.GetChild("v3io-prom-adapter"),
+		tenants:        map[string]*tsdb.V3ioAdapter{},
+		allowedTenants: tenantAllowListSet(cfg.TenantAllowList),
+	}
+
 	adapter, err := tsdb.NewV3ioAdapter(cfg, nil, logger)
-	newAdapter := V3ioPromAdapter{db: adapter, logger: logger.GetChild("v3io-prom-adapter")}
-	return &newAdapter, err
+	if err != nil {
+		return nil, err
+	}
+	newAdapter.tenants[DefaultTenant] = adapter
+
+	return &newAdapter, nil
 }
 
 func (a *V3ioPromAdapter) SetUseV3ioAggregations(useV3ioAggregations bool) {
 	a.useV3ioAggregations = useV3ioAggregations
 }
 
+// Appender satisfies storage.Appendable, which (at the vintage of the storage interfaces this adapter
+// targets) takes no context, so it always appends to DefaultTenant. Callers that do have a per-request
+// tenant available (e.g. the OTLP ingestion handler) should use TenantAppender instead.
 func (a *V3ioPromAdapter) Appender() (storage.Appender, error) {
-	err := a.db.InitAppenderCache()
+	return a.TenantAppender(DefaultTenant)
+}
+
+// TenantAppender returns a storage.Appender bound to tenant, for callers that sit behind a per-request
+// context (and so can resolve a tenant via TenantFromContext) but are handed a tenant-agnostic
+// storage.Appendable contract, such as the OTLP ingestion handler.
+func (a *V3ioPromAdapter) TenantAppender(tenant string) (storage.Appender, error) {
+	dbAdapter, err := a.tenantAdapter(tenant)
 	if err != nil {
 		return nil, err
 	}
 
-	newAppender := v3ioAppender{metricsCache: a.db.MetricsCache}
-	return newAppender, nil
+	if err := dbAdapter.InitAppenderCache(); err != nil {
+		return nil, err
+	}
+
+	return v3ioAppender{metricsCache: dbAdapter.MetricsCache}, nil
 }
 
 func (a *V3ioPromAdapter) StartTime() (int64, error) {
-	return a.db.StartTime()
+	dbAdapter, err := a.tenantAdapter(DefaultTenant)
+	if err != nil {
+		return 0, err
+	}
+	return dbAdapter.StartTime()
 }
 
 func (a *V3ioPromAdapter) Close() error {
 	return nil
 }
 
-func (a *V3ioPromAdapter) Querier(_ context.Context, mint, maxt int64) (storage.Querier, error) {
-	v3ioQuerier, err := a.db.QuerierV2()
+func (a *V3ioPromAdapter) Querier(ctx context.Context, mint, maxt int64) (storage.Querier, error) {
+	dbAdapter, err := a.tenantAdapter(TenantFromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	v3ioQuerier, err := dbAdapter.QuerierV2()
 	promQuerier := V3ioPromQuerier{v3ioQuerier: v3ioQuerier,
 		logger: a.logger.GetChild("v3io-prom-query"),
 		mint:   mint, maxt: maxt,
-		UseAggregatesConfig: a.useV3ioAggregations}
+		ctx:                 ctx,
+		UseAggregatesConfig: a.useV3ioAggregations,
+		DefaultLimit:        a.defaultQueryLimit,
+		QueryStreamBatchSize: streamBatchSizeOrDefault(a.baseCfg.QueryStreamBatchSize)}
 	return &promQuerier, err
 }
 
+// SetDefaultQueryLimit sets the default max number of series/label values a query returns when it does not
+// request its own limit (passed from prometheus.yml). Zero means unlimited.
+func (a *V3ioPromAdapter) SetDefaultQueryLimit(limit int) {
+	a.defaultQueryLimit = limit
+}
+
+// tenantAdapter returns the tsdb adapter bound to tenant, lazily creating and caching one (against its own
+// container/prefix, derived from V3ioConfig.TenantPathTemplate or defaultTenantPathTemplate) the first time
+// the tenant is seen.
+func (a *V3ioPromAdapter) tenantAdapter(tenant string) (*tsdb.V3ioAdapter, error) {
+	if err := a.validateTenant(tenant); err != nil {
+		return nil, err
+	}
+
+	a.tenantsLock.RLock()
+	dbAdapter, ok := a.tenants[tenant]
+	a.tenantsLock.RUnlock()
+	if ok {
+		return dbAdapter, nil
+	}
+
+	a.tenantsLock.Lock()
+	defer a.tenantsLock.Unlock()
+
+	if dbAdapter, ok := a.tenants[tenant]; ok {
+		return dbAdapter, nil
+	}
+
+	tenantCfg := *a.baseCfg
+	tenantCfg.Path = fmt.Sprintf(tenantPathTemplateOrDefault(a.baseCfg.TenantPathTemplate), tenant)
+
+	dbAdapter, err := tsdb.NewV3ioAdapter(&tenantCfg, nil, a.logger)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create tsdb adapter for tenant %q", tenant)
+	}
+
+	a.tenants[tenant] = dbAdapter
+	return dbAdapter, nil
+}
+
+func (a *V3ioPromAdapter) validateTenant(tenant string) error {
+	maxLen := a.baseCfg.TenantIDMaxLength
+	if maxLen > 0 && len(tenant) > maxLen {
+		return errors.Errorf("tenant id %q exceeds the configured max length of %d", tenant, maxLen)
+	}
+
+	if a.allowedTenants != nil && !a.allowedTenants[tenant] {
+		return errors.Errorf("tenant id %q is not in the configured allow-list", tenant)
+	}
+
+	return nil
+}
+
 type V3ioPromQuerier struct {
 	v3ioQuerier *pquerier.V3ioQuerier
 	logger      logger.Logger
 	mint, maxt  int64
+	ctx         context.Context // carried from Querier(ctx, ...), used to cancel in-flight streaming reads
 
 	UseAggregatesConfig    bool // Indicate whether or not to use v3io aggregations by default (passed from prometheus.yml)
 	UseAggregates          bool // Indicate whether the current query is eligible for using v3io aggregations (should be set after creating a Querier instance)
 	LastTSDBAggregatedAggr string
+
+	DefaultLimit         int // Max number of series/label values to return when the caller does not specify its own limit
+	QueryStreamBatchSize int // Number of samples buffered per chunk batch pushed through the streaming SeriesSet
+
+	streamCancels []context.CancelFunc // cancel funcs for Select calls still streaming, invoked on Close
 }
 
 func (promQuery *V3ioPromQuerier) UseV3ioAggregations() bool {
@@ -98,12 +199,7 @@ func (promQuery *V3ioPromQuerier) Select(params *storage.SelectParams, oms ...*l
 
 	// if a nil params is passed we assume it's a metadata query, so we fetch only the different labelsets withtout data.
 	if params == nil {
-		labelSets, err := promQuery.v3ioQuerier.GetLabelSets(name, filter)
-		if err != nil {
-			return nil, nil, err
-		}
-
-		return &V3ioPromSeriesSet{newMetadataSeriesSet(labelSets)}, nil, nil
+		return promQuery.metadataSelect(name, filter, promQuery.DefaultLimit)
 	}
 
 	promQuery.logger.Debug("SelectParams: %+v", params)
@@ -147,33 +243,97 @@ func (promQuery *V3ioPromQuerier) Select(params *storage.SelectParams, oms ...*l
 		aggrWindow = 0
 	}
 
+	// This storage.SelectParams vintage has no Limit/Hints field to carry a per-query limit, so the
+	// config-driven DefaultLimit is the only source for one.
+	//
+	// pquerier.SelectParams.Limit, along with the v3ioQuerier.LabelValues/LabelNames(name, filter) and
+	// SelectPromStream(...) calls and pquerier.ParseQuery used elsewhere in this package, are pinned against
+	// whatever v3io-tsdb version this binary was last built with; this tree has no go.mod or vendored copy of
+	// v3io-tsdb to check them against, so they could not be re-verified here. Confirm them against the
+	// pinned v3io-tsdb version before relying on this code path.
+	limit := promQuery.DefaultLimit
+
 	selectParams := &pquerier.SelectParams{Name: name,
 		Functions:         function,
 		Step:              step,
 		Filter:            filter,
 		From:              promQuery.mint,
 		To:                promQuery.maxt,
-		AggregationWindow: aggrWindow}
+		AggregationWindow: aggrWindow,
+		Limit:             limit}
 
 	promQuery.logger.DebugWith("Going to query tsdb", "params", selectParams,
 		"UseAggregates", promQuery.UseAggregates, "UseAggregatesConfig", promQuery.UseAggregatesConfig)
-	set, err := promQuery.v3ioQuerier.SelectProm(selectParams, noAggr)
-	return &V3ioPromSeriesSet{s: set}, nil, err
+
+	set, warnings, err := promQuery.selectStream(selectParams, noAggr, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &V3ioPromSeriesSet{s: set}, warnings, nil
 }
 
-// LabelValues returns all potential values for a label name.
-func (promQuery *V3ioPromQuerier) LabelValues(name string) ([]string, storage.Warnings, error) {
-	values, err := promQuery.v3ioQuerier.LabelValues(name)
-	return values, nil, err
+// metadataSelect fetches the distinct label sets matching name/filter, without any sample data, truncating to
+// limit label sets when one is set.
+func (promQuery *V3ioPromQuerier) metadataSelect(name, filter string, limit int) (storage.SeriesSet, storage.Warnings, error) {
+	labelSets, err := promQuery.v3ioQuerier.GetLabelSets(name, filter)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var truncated bool
+	labelSets, truncated = truncateLabelSets(labelSets, limit)
+
+	return &V3ioPromSeriesSet{newMetadataSeriesSet(labelSets)}, truncationWarnings(truncated), nil
+}
+
+// LabelValues returns all potential values for a label name, optionally restricted by matchers, truncated to
+// DefaultLimit values when one is set.
+func (promQuery *V3ioPromQuerier) LabelValues(name string, oms ...*labels.Matcher) ([]string, storage.Warnings, error) {
+	values, err := promQuery.v3ioQuerier.LabelValues(name, matcherFilter(oms, promQuery.logger))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	values, truncated := truncateStrings(values, promQuery.DefaultLimit)
+	return values, truncationWarnings(truncated), nil
+}
+
+// LabelNames returns all the unique label names present, optionally restricted by matchers, truncated to
+// DefaultLimit names when one is set.
+func (promQuery *V3ioPromQuerier) LabelNames(oms ...*labels.Matcher) ([]string, storage.Warnings, error) {
+	values, err := promQuery.v3ioQuerier.LabelNames(matcherFilter(oms, promQuery.logger))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	values, truncated := truncateStrings(values, promQuery.DefaultLimit)
+	return values, truncationWarnings(truncated), nil
 }
 
-func (promQuery *V3ioPromQuerier) LabelNames() ([]string, storage.Warnings, error) {
-	values, err := promQuery.v3ioQuerier.LabelNames()
-	return values, nil, err
+// matcherFilter translates oms into a v3io filter expression, same as match2filter, except that a
+// `__name__` equality matcher is folded into the filter itself rather than split out separately. Unlike
+// Select, LabelValues/LabelNames have no dedicated metric-name parameter on the underlying
+// pquerier.V3ioQuerier calls, so the metric name has to travel as an ordinary filter term or it's silently
+// dropped, e.g. `label_values(up{job="foo"}, instance)` returning instance values across all metrics.
+func matcherFilter(oms []*labels.Matcher, logger logger.Logger) string {
+	name, filter, _ := match2filter(oms, logger)
+	if name == "" {
+		return filter
+	}
+
+	nameFilter := fmt.Sprintf("%s=='%s'", labels.MetricName, name)
+	if filter == "" {
+		return nameFilter
+	}
+	return nameFilter + " and " + filter
 }
 
-// Close releases the resources of the Querier.
+// Close releases the resources of the Querier, cancelling any in-flight streaming Select reads.
 func (promQuery *V3ioPromQuerier) Close() error {
+	for _, cancel := range promQuery.streamCancels {
+		cancel()
+	}
 	return nil
 }
 
@@ -337,6 +497,46 @@ func (ls Labels) LabelNames() []string {
 	return res
 }
 
+// truncatedResultsWarning is returned whenever a limit caused results to be cut short, mirroring the
+// `limit` query parameter added to Prometheus's series/labels APIs.
+const truncatedResultsWarning = "results truncated due to limit"
+
+func truncationWarnings(truncated bool) storage.Warnings {
+	if !truncated {
+		return nil
+	}
+	return storage.Warnings{errors.New(truncatedResultsWarning)}
+}
+
+func truncateLabelSets(labelSets []utils.Labels, limit int) ([]utils.Labels, bool) {
+	if limit <= 0 || len(labelSets) <= limit {
+		return labelSets, false
+	}
+	return labelSets[:limit], true
+}
+
+func truncateStrings(values []string, limit int) ([]string, bool) {
+	if limit <= 0 || len(values) <= limit {
+		return values, false
+	}
+	return values[:limit], true
+}
+
+// bufferedSeriesSet iterates a slice of already-fetched series, e.g. the first limit+1 series peeled off a
+// streaming Select so truncation can be detected without buffering the full result set.
+type bufferedSeriesSet struct {
+	series       []utils.Series
+	currentIndex int
+	err          error
+}
+
+func (ss *bufferedSeriesSet) Next() bool {
+	ss.currentIndex++
+	return ss.currentIndex < len(ss.series)
+}
+func (ss *bufferedSeriesSet) At() utils.Series { return ss.series[ss.currentIndex] }
+func (ss *bufferedSeriesSet) Err() error        { return ss.err }
+
 func newMetadataSeriesSet(labels []utils.Labels) utils.SeriesSet {
 	return &metadataSeriesSet{labels: labels, currentIndex: -1, size: len(labels)}
 }
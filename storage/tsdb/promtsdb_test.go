@@ -0,0 +1,176 @@
+package tsdb
+
+import (
+	"testing"
+
+	nucliologger "github.com/nuclio/logger"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+)
+
+func testLogger(t *testing.T) nucliologger.Logger {
+	l, err := utils.NewLogger("error")
+	if err != nil {
+		t.Fatalf("failed to create logger: %v", err)
+	}
+	return l
+}
+
+func TestMatch2Filter(t *testing.T) {
+	cases := []struct {
+		name       string
+		matchers   []*labels.Matcher
+		wantName   string
+		wantFilter string
+	}{
+		{
+			name:       "equality",
+			matchers:   []*labels.Matcher{{Type: labels.MatchEqual, Name: "job", Value: "foo"}},
+			wantFilter: "job=='foo'",
+		},
+		{
+			name:       "inequality",
+			matchers:   []*labels.Matcher{{Type: labels.MatchNotEqual, Name: "job", Value: "foo"}},
+			wantFilter: "job!='foo'",
+		},
+		{
+			name:       "regexp",
+			matchers:   []*labels.Matcher{{Type: labels.MatchRegexp, Name: "job", Value: "fo.*"}},
+			wantFilter: "regexp_instr(job,'fo.*') == 0",
+		},
+		{
+			name:       "not_regexp",
+			matchers:   []*labels.Matcher{{Type: labels.MatchNotRegexp, Name: "job", Value: "fo.*"}},
+			wantFilter: "regexp_instr(job,'fo.*') != 0",
+		},
+		{
+			name:     "metric_name_equality_extracted",
+			matchers: []*labels.Matcher{{Type: labels.MatchEqual, Name: labels.MetricName, Value: "up"}},
+			wantName: "up",
+		},
+		{
+			name: "metric_name_and_filter",
+			matchers: []*labels.Matcher{
+				{Type: labels.MatchEqual, Name: labels.MetricName, Value: "up"},
+				{Type: labels.MatchEqual, Name: "job", Value: "foo"},
+			},
+			wantName:   "up",
+			wantFilter: "job=='foo'",
+		},
+	}
+
+	logger := testLogger(t)
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			name, filter, _ := match2filter(tc.matchers, logger)
+			if name != tc.wantName {
+				t.Errorf("name = %q, want %q", name, tc.wantName)
+			}
+			if filter != tc.wantFilter {
+				t.Errorf("filter = %q, want %q", filter, tc.wantFilter)
+			}
+		})
+	}
+}
+
+// TestMatcherFilterFoldsMetricName covers the case match2filter alone mishandles for LabelValues/LabelNames:
+// a __name__ matcher, e.g. from label_values(up{job="foo"}, instance), must not be silently dropped.
+func TestMatcherFilterFoldsMetricName(t *testing.T) {
+	logger := testLogger(t)
+
+	cases := []struct {
+		name     string
+		matchers []*labels.Matcher
+		want     string
+	}{
+		{
+			name:     "name_only",
+			matchers: []*labels.Matcher{{Type: labels.MatchEqual, Name: labels.MetricName, Value: "up"}},
+			want:     "__name__=='up'",
+		},
+		{
+			name: "name_and_other_filter",
+			matchers: []*labels.Matcher{
+				{Type: labels.MatchEqual, Name: labels.MetricName, Value: "up"},
+				{Type: labels.MatchEqual, Name: "job", Value: "foo"},
+			},
+			want: "__name__=='up' and job=='foo'",
+		},
+		{
+			name:     "no_name",
+			matchers: []*labels.Matcher{{Type: labels.MatchEqual, Name: "job", Value: "foo"}},
+			want:     "job=='foo'",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := matcherFilter(tc.matchers, logger)
+			if got != tc.want {
+				t.Errorf("matcherFilter() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTruncateStrings(t *testing.T) {
+	cases := []struct {
+		name          string
+		values        []string
+		limit         int
+		wantValues    []string
+		wantTruncated bool
+	}{
+		{name: "no_limit", values: []string{"a", "b", "c"}, limit: 0, wantValues: []string{"a", "b", "c"}},
+		{name: "under_limit", values: []string{"a", "b"}, limit: 5, wantValues: []string{"a", "b"}},
+		{name: "over_limit", values: []string{"a", "b", "c"}, limit: 2, wantValues: []string{"a", "b"}, wantTruncated: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, truncated := truncateStrings(tc.values, tc.limit)
+			if truncated != tc.wantTruncated {
+				t.Errorf("truncated = %v, want %v", truncated, tc.wantTruncated)
+			}
+			if len(got) != len(tc.wantValues) {
+				t.Errorf("got %v, want %v", got, tc.wantValues)
+			}
+		})
+	}
+}
+
+func TestTruncateLabelSets(t *testing.T) {
+	labelSets := []utils.Labels{nil, nil, nil}
+
+	got, truncated := truncateLabelSets(labelSets, 2)
+	if !truncated {
+		t.Error("truncated = false, want true")
+	}
+	if len(got) != 2 {
+		t.Errorf("got %d label sets, want 2", len(got))
+	}
+
+	got, truncated = truncateLabelSets(labelSets, 0)
+	if truncated {
+		t.Error("truncated = true, want false when limit is 0 (unlimited)")
+	}
+	if len(got) != len(labelSets) {
+		t.Errorf("got %d label sets, want %d", len(got), len(labelSets))
+	}
+}
+
+// TestTruncationWarnings covers the warning-propagation half of the truncation contract: callers such as
+// LabelValues/LabelNames/metadataSelect surface a warning if and only if results were actually truncated.
+func TestTruncationWarnings(t *testing.T) {
+	if w := truncationWarnings(false); w != nil {
+		t.Errorf("truncationWarnings(false) = %v, want nil", w)
+	}
+
+	w := truncationWarnings(true)
+	if len(w) != 1 {
+		t.Fatalf("truncationWarnings(true) = %v, want a single warning", w)
+	}
+	if w[0].Error() != truncatedResultsWarning {
+		t.Errorf("warning = %q, want %q", w[0].Error(), truncatedResultsWarning)
+	}
+}
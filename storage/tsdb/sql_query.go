@@ -0,0 +1,135 @@
+package tsdb
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/v3io/v3io-tsdb/pkg/pquerier"
+)
+
+// Query runs a SQL/PromSQL statement (e.g. "select avg(cpu), max(cpu) from metrics where os=='linux' group by host")
+// against the v3io TSDB. It lets callers express multi-aggregate, cross-series queries that PromQL cannot,
+// such as several aggregate functions in a single pass or a `group by` on arbitrary labels.
+func (a *V3ioPromAdapter) Query(ctx context.Context, sql string, mint, maxt int64) (storage.SeriesSet, error) {
+	selectParams, _, err := pquerier.ParseQuery(sql)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse SQL query")
+	}
+
+	selectParams.From = mint
+	selectParams.To = maxt
+
+	dbAdapter, err := a.tenantAdapter(TenantFromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	v3ioQuerier, err := dbAdapter.QuerierV2()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create querier")
+	}
+
+	set, err := v3ioQuerier.SelectProm(selectParams, false)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to run SQL query")
+	}
+
+	return &V3ioPromSeriesSet{s: set}, nil
+}
+
+// RegisterSQLQueryHandler registers the SQL/PromSQL query endpoint on mux, alongside Prometheus's standard
+// /api/v1/query routes.
+func RegisterSQLQueryHandler(mux *http.ServeMux, a *V3ioPromAdapter) {
+	mux.HandleFunc("/api/v1/query_sql", func(w http.ResponseWriter, r *http.Request) {
+		handleSQLQuery(w, r, a)
+	})
+}
+
+func handleSQLQuery(w http.ResponseWriter, r *http.Request, a *V3ioPromAdapter) {
+	sql := r.FormValue("query")
+	if sql == "" {
+		http.Error(w, "missing 'query' parameter", http.StatusBadRequest)
+		return
+	}
+
+	mint, err := parseUnixTimeParam(r, "start")
+	if err != nil {
+		http.Error(w, errors.Wrap(err, "invalid 'start' parameter").Error(), http.StatusBadRequest)
+		return
+	}
+
+	maxt, err := parseUnixTimeParam(r, "end")
+	if err != nil {
+		http.Error(w, errors.Wrap(err, "invalid 'end' parameter").Error(), http.StatusBadRequest)
+		return
+	}
+
+	set, err := a.Query(r.Context(), sql, mint, maxt)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeSeriesSetJSON(w, set)
+}
+
+// parseUnixTimeParam parses a Prometheus-style unix-seconds query parameter into milliseconds.
+func parseUnixTimeParam(r *http.Request, name string) (int64, error) {
+	value := r.FormValue(name)
+	if value == "" {
+		return 0, errors.Errorf("missing '%s' parameter", name)
+	}
+
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(seconds * 1000), nil
+}
+
+// sqlQueryResult is a single series in the JSON response, shaped like Prometheus's matrix result.
+type sqlQueryResult struct {
+	Metric map[string]string `json:"metric"`
+	Values [][2]interface{}  `json:"values"`
+}
+
+func writeSeriesSetJSON(w http.ResponseWriter, set storage.SeriesSet) {
+	var results []sqlQueryResult
+
+	for set.Next() {
+		series := set.At()
+
+		metric := map[string]string{}
+		for _, l := range series.Labels() {
+			metric[l.Name] = l.Value
+		}
+
+		var values [][2]interface{}
+		it := series.Iterator()
+		for it.Next() {
+			t, v := it.At()
+			values = append(values, [2]interface{}{float64(t) / 1000, strconv.FormatFloat(v, 'f', -1, 64)})
+		}
+
+		results = append(results, sqlQueryResult{Metric: metric, Values: values})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := set.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"data": map[string]interface{}{
+			"resultType": "matrix",
+			"result":     results,
+		},
+	})
+}
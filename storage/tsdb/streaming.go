@@ -0,0 +1,110 @@
+package tsdb
+
+import (
+	"context"
+
+	"github.com/prometheus/prometheus/storage"
+	"github.com/v3io/v3io-tsdb/pkg/pquerier"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+)
+
+// defaultQueryStreamBatchSize is used when V3ioConfig.QueryStreamBatchSize is unset.
+const defaultQueryStreamBatchSize = 512
+
+func streamBatchSizeOrDefault(batchSize int) int {
+	if batchSize <= 0 {
+		return defaultQueryStreamBatchSize
+	}
+	return batchSize
+}
+
+// selectStream dispatches params as a streaming v3io query: chunks are decoded on the v3io side and pushed,
+// series by series, through a bounded channel, so PromQL can start evaluating before the whole time range is
+// read and memory for a consumed series can be released before the next one arrives.
+//
+// When limit is set we only need to look far enough ahead to tell whether results were truncated, so we
+// buffer at most limit+1 series rather than the full result set; otherwise the returned SeriesSet keeps
+// pulling from the channel on demand.
+func (promQuery *V3ioPromQuerier) selectStream(params *pquerier.SelectParams, noAggr bool, limit int) (utils.SeriesSet, storage.Warnings, error) {
+	ctx, cancel := context.WithCancel(promQuery.ctx)
+
+	seriesCh, errCh, err := promQuery.v3ioQuerier.SelectPromStream(ctx, params, noAggr, promQuery.QueryStreamBatchSize)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+
+	promQuery.streamCancels = append(promQuery.streamCancels, cancel)
+
+	if limit <= 0 {
+		return newStreamingSeriesSet(ctx, cancel, seriesCh, errCh), nil, nil
+	}
+
+	var buffered []utils.Series
+	for len(buffered) <= limit {
+		series, ok := <-seriesCh
+		if !ok {
+			break
+		}
+		buffered = append(buffered, series)
+	}
+	cancel()
+
+	truncated := len(buffered) > limit
+	if truncated {
+		buffered = buffered[:limit]
+	}
+
+	return &bufferedSeriesSet{series: buffered, currentIndex: -1, err: drainStreamErr(errCh)}, truncationWarnings(truncated), nil
+}
+
+// drainStreamErr returns a pending terminal error from errCh without blocking, for use right after the
+// producer has been cancelled.
+func drainStreamErr(errCh <-chan error) error {
+	select {
+	case err := <-errCh:
+		return err
+	default:
+		return nil
+	}
+}
+
+// streamingSeriesSet lazily pulls series off a bounded channel fed by parallel v3io range reads, releasing
+// the previous series for GC as soon as the caller moves on to the next one.
+type streamingSeriesSet struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	seriesCh <-chan utils.Series
+	errCh    <-chan error
+
+	current utils.Series
+	err     error
+}
+
+func newStreamingSeriesSet(ctx context.Context, cancel context.CancelFunc, seriesCh <-chan utils.Series,
+	errCh <-chan error) *streamingSeriesSet {
+
+	return &streamingSeriesSet{ctx: ctx, cancel: cancel, seriesCh: seriesCh, errCh: errCh}
+}
+
+func (s *streamingSeriesSet) Next() bool {
+	select {
+	case series, ok := <-s.seriesCh:
+		if !ok {
+			return false
+		}
+		s.current = series
+		return true
+	case err := <-s.errCh:
+		s.err = err
+		s.cancel()
+		return false
+	case <-s.ctx.Done():
+		s.err = s.ctx.Err()
+		return false
+	}
+}
+
+func (s *streamingSeriesSet) At() utils.Series { return s.current }
+func (s *streamingSeriesSet) Err() error        { return s.err }
@@ -0,0 +1,154 @@
+package tsdb
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+)
+
+// fakeSeries is a minimal utils.Series used to drive streamingSeriesSet/bufferedSeriesSet without a real
+// v3io-tsdb backend.
+type fakeSeries struct {
+	key uint64
+}
+
+func (s fakeSeries) Labels() utils.Labels           { return nil }
+func (s fakeSeries) Iterator() utils.SeriesIterator { return utils.NullSeriesIterator{} }
+func (s fakeSeries) GetKey() uint64                 { return s.key }
+
+func fakeSeriesN(n int) []utils.Series {
+	series := make([]utils.Series, n)
+	for i := range series {
+		series[i] = fakeSeries{key: uint64(i)}
+	}
+	return series
+}
+
+func TestBufferedSeriesSetIteratesAllSeries(t *testing.T) {
+	series := fakeSeriesN(3)
+	ss := &bufferedSeriesSet{series: series, currentIndex: -1}
+
+	var got []utils.Series
+	for ss.Next() {
+		got = append(got, ss.At())
+	}
+	if ss.Err() != nil {
+		t.Fatalf("Err() = %v, want nil", ss.Err())
+	}
+	if len(got) != len(series) {
+		t.Fatalf("iterated %d series, want %d", len(got), len(series))
+	}
+}
+
+func TestBufferedSeriesSetPropagatesErr(t *testing.T) {
+	wantErr := errors.New("boom")
+	ss := &bufferedSeriesSet{series: fakeSeriesN(1), currentIndex: -1, err: wantErr}
+
+	for ss.Next() {
+	}
+	if ss.Err() != wantErr {
+		t.Errorf("Err() = %v, want %v", ss.Err(), wantErr)
+	}
+}
+
+func TestStreamingSeriesSetPullsFromChannel(t *testing.T) {
+	seriesCh := make(chan utils.Series, 2)
+	errCh := make(chan error, 1)
+	series := fakeSeriesN(2)
+	seriesCh <- series[0]
+	seriesCh <- series[1]
+	close(seriesCh)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ss := newStreamingSeriesSet(ctx, cancel, seriesCh, errCh)
+
+	var got []utils.Series
+	for ss.Next() {
+		got = append(got, ss.At())
+	}
+	if ss.Err() != nil {
+		t.Fatalf("Err() = %v, want nil", ss.Err())
+	}
+	if len(got) != len(series) {
+		t.Fatalf("iterated %d series, want %d", len(got), len(series))
+	}
+}
+
+func TestStreamingSeriesSetStopsOnError(t *testing.T) {
+	seriesCh := make(chan utils.Series)
+	errCh := make(chan error, 1)
+	wantErr := errors.New("boom")
+	errCh <- wantErr
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ss := newStreamingSeriesSet(ctx, cancel, seriesCh, errCh)
+
+	if ss.Next() {
+		t.Fatal("Next() = true, want false on producer error")
+	}
+	if ss.Err() != wantErr {
+		t.Errorf("Err() = %v, want %v", ss.Err(), wantErr)
+	}
+}
+
+func TestStreamingSeriesSetStopsOnCancel(t *testing.T) {
+	seriesCh := make(chan utils.Series)
+	errCh := make(chan error)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	ss := newStreamingSeriesSet(ctx, cancel, seriesCh, errCh)
+	if ss.Next() {
+		t.Fatal("Next() = true, want false once the context is cancelled")
+	}
+	if ss.Err() != context.Canceled {
+		t.Errorf("Err() = %v, want %v", ss.Err(), context.Canceled)
+	}
+}
+
+func TestStreamBatchSizeOrDefault(t *testing.T) {
+	if got := streamBatchSizeOrDefault(0); got != defaultQueryStreamBatchSize {
+		t.Errorf("streamBatchSizeOrDefault(0) = %d, want %d", got, defaultQueryStreamBatchSize)
+	}
+	if got := streamBatchSizeOrDefault(128); got != 128 {
+		t.Errorf("streamBatchSizeOrDefault(128) = %d, want 128", got)
+	}
+}
+
+// BenchmarkStreamingSeriesSet demonstrates that iterating a large result set through streamingSeriesSet
+// holds at most defaultQueryStreamBatchSize buffered series plus the current one, rather than the full
+// result set, by reporting allocations for a channel-bound producer/consumer pair instead of a fully
+// materialized slice.
+func BenchmarkStreamingSeriesSet(b *testing.B) {
+	const seriesCount = 10000
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		seriesCh := make(chan utils.Series, defaultQueryStreamBatchSize)
+		errCh := make(chan error, 1)
+
+		go func() {
+			defer close(seriesCh)
+			for j := 0; j < seriesCount; j++ {
+				seriesCh <- fakeSeries{key: uint64(j)}
+			}
+		}()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		ss := newStreamingSeriesSet(ctx, cancel, seriesCh, errCh)
+
+		count := 0
+		for ss.Next() {
+			_ = ss.At()
+			count++
+		}
+		cancel()
+
+		if count != seriesCount {
+			b.Fatalf("iterated %d series, want %d", count, seriesCount)
+		}
+	}
+}
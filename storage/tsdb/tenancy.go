@@ -0,0 +1,74 @@
+package tsdb
+
+import (
+	"context"
+	"net/http"
+)
+
+// DefaultTenantHeader is the HTTP header used to carry the tenant ID when V3ioConfig.TenantHeader is unset.
+const DefaultTenantHeader = "THANOS-TENANT"
+
+// DefaultTenant is used for both the remote-write and query paths when no tenant header is present.
+const DefaultTenant = "default"
+
+// defaultTenantPathTemplate derives a tenant's v3io container path from its tenant ID when
+// V3ioConfig.TenantPathTemplate is unset.
+const defaultTenantPathTemplate = "users/%s/tsdb"
+
+type tenantContextKey struct{}
+
+// WithTenant returns a copy of ctx carrying tenant, for propagation to Appender/Querier.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenant)
+}
+
+// TenantFromContext extracts the tenant ID previously attached with WithTenant, falling back to
+// DefaultTenant when none was set.
+func TenantFromContext(ctx context.Context) string {
+	if tenant, ok := ctx.Value(tenantContextKey{}).(string); ok && tenant != "" {
+		return tenant
+	}
+	return DefaultTenant
+}
+
+// TenantMiddleware extracts the tenant ID from the request's tenant header (header defaults to
+// DefaultTenantHeader) and attaches it to the request context, so that downstream Appender/Querier calls
+// route to the right per-tenant adapter.
+func TenantMiddleware(header string, next http.Handler) http.Handler {
+	if header == "" {
+		header = DefaultTenantHeader
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant := r.Header.Get(header)
+		if tenant == "" {
+			tenant = DefaultTenant
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithTenant(r.Context(), tenant)))
+	})
+}
+
+func tenantPathTemplateOrDefault(template string) string {
+	if template == "" {
+		return defaultTenantPathTemplate
+	}
+	return template
+}
+
+// tenantAllowListSet builds the allow-list lookup from allowList. DefaultTenant is deliberately NOT added
+// implicitly: once an operator configures a restrictive allow-list, a request with no tenant header (or an
+// explicit "THANOS-TENANT: default") must not fall through to the shared default tenant's data unless
+// "default" is itself listed.
+func tenantAllowListSet(allowList []string) map[string]bool {
+	if len(allowList) == 0 {
+		return nil
+	}
+
+	set := make(map[string]bool, len(allowList))
+	for _, tenant := range allowList {
+		set[tenant] = true
+	}
+
+	return set
+}
@@ -0,0 +1,23 @@
+package tsdb
+
+import "testing"
+
+func TestTenantAllowListSet(t *testing.T) {
+	if got := tenantAllowListSet(nil); got != nil {
+		t.Errorf("tenantAllowListSet(nil) = %v, want nil (no restriction)", got)
+	}
+
+	set := tenantAllowListSet([]string{"a", "b"})
+	for _, tenant := range []string{"a", "b"} {
+		if !set[tenant] {
+			t.Errorf("tenant %q missing from allow-list set", tenant)
+		}
+	}
+
+	// DefaultTenant must not be implicitly reachable once an allow-list is configured: an operator relying
+	// on a restrictive allow-list should not have "THANOS-TENANT: default" (or no header at all) silently
+	// fall through to the shared default tenant's data.
+	if set[DefaultTenant] {
+		t.Errorf("tenantAllowListSet() implicitly allowed %q; it must be listed explicitly", DefaultTenant)
+	}
+}